@@ -0,0 +1,208 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package integration
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	runtime "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+// cniFailpoint relocates a CNI plugin binary for the duration of a test so
+// that invoking it returns an error, simulating a failure at that specific
+// point in the CNI plugin chain without needing a custom plugin build.
+type cniFailpoint struct {
+	binPath string
+	broken  string
+}
+
+// breakCNIPlugin renames the named plugin binary (looked up in
+// CRIConfig().NetworkPluginBinDir) so CNI can no longer find it. Callers
+// should defer the returned failpoint's restore method.
+func breakCNIPlugin(t *testing.T, name string) *cniFailpoint {
+	config, err := CRIConfig()
+	require.NoError(t, err)
+	binPath := filepath.Join(config.NetworkPluginBinDir, name)
+	broken := binPath + ".disabled"
+	require.NoError(t, os.Rename(binPath, broken))
+	return &cniFailpoint{binPath: binPath, broken: broken}
+}
+
+// restore puts the plugin binary back in place. It is safe to call more than
+// once, so it can unconditionally be deferred right after breakCNIPlugin.
+func (p *cniFailpoint) restore() error {
+	if _, err := os.Stat(p.broken); os.IsNotExist(err) {
+		return nil
+	}
+	return os.Rename(p.broken, p.binPath)
+}
+
+// PidsOf returns the pids of all running processes with the given binary
+// name. Tests use it to assert that a failed CNI ADD/DEL does not leave an
+// orphaned plugin child process behind.
+func PidsOf(name string) ([]int, error) {
+	out, err := exec.Command("pgrep", "-x", name).CombinedOutput()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+			// pgrep exits with status 1 when no process matches.
+			return nil, nil
+		}
+		return nil, err
+	}
+	var pids []int
+	for _, field := range strings.Fields(string(out)) {
+		pid, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, err
+		}
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
+
+func findSandbox(sandboxes []*runtime.PodSandbox, name, namespace string) *runtime.PodSandbox {
+	for _, sb := range sandboxes {
+		m := sb.GetMetadata()
+		if m.GetName() == name && m.GetNamespace() == namespace {
+			return sb
+		}
+	}
+	return nil
+}
+
+func hostLocalCheckpointExists(t *testing.T, ip string) bool {
+	found := false
+	err := filepath.Walk("/var/lib/cni", func(_ string, info os.FileInfo, _ error) error {
+		if info != nil && info.Name() == ip {
+			found = true
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	return found
+}
+
+// TestSandboxNetworkAddFailureAfterIPAM covers ADD failing on a non-IPAM
+// plugin further down the chain after host-local IPAM has already allocated
+// an IP: RunPodSandbox must fail, but the partial allocation must be left in
+// a retryable state rather than leaked, and no plugin process should be left
+// running.
+func TestSandboxNetworkAddFailureAfterIPAM(t *testing.T) {
+	config, err := CRIConfig()
+	require.NoError(t, err)
+	fs, err := ioutil.ReadDir(config.NetworkPluginConfDir)
+	require.NoError(t, err)
+	require.NotEmpty(t, fs)
+	cniConfig, err := ioutil.ReadFile(filepath.Join(config.NetworkPluginConfDir, fs[0].Name()))
+	require.NoError(t, err)
+	if !strings.Contains(string(cniConfig), "host-local") || !strings.Contains(string(cniConfig), "portmap") {
+		t.Skip("host-local ipam with portmap chained is not in use")
+	}
+
+	fp := breakCNIPlugin(t, "portmap")
+	defer fp.restore()
+
+	sbConfig := PodSandboxConfig("sandbox", "network-add-failure-after-ipam")
+	_, err = runtimeService.RunPodSandbox(sbConfig)
+	require.Error(t, err, "RunPodSandbox should fail when a chained plugin fails")
+
+	t.Logf("Sandbox should still be listed, in a retryable state")
+	sandboxes, err := runtimeService.ListPodSandbox(nil)
+	require.NoError(t, err)
+	sb := findSandbox(sandboxes, "sandbox", "network-add-failure-after-ipam")
+	require.NotNil(t, sb)
+	defer func() {
+		runtimeService.StopPodSandbox(sb.GetId())
+		runtimeService.RemovePodSandbox(sb.GetId())
+	}()
+
+	pids, err := PidsOf("portmap")
+	require.NoError(t, err)
+	assert.Empty(t, pids, "no portmap child process should be left running")
+
+	status, err := runtimeService.PodSandboxStatus(sb.GetId())
+	require.NoError(t, err)
+	ip := status.GetNetwork().GetIp()
+	require.NotEmpty(t, ip)
+	assert.True(t, hostLocalCheckpointExists(t, ip))
+
+	require.NoError(t, fp.restore())
+
+	t.Logf("Retrying teardown and removal should now succeed and release the IP")
+	assert.NoError(t, runtimeService.StopPodSandbox(sb.GetId()))
+	assert.NoError(t, runtimeService.RemovePodSandbox(sb.GetId()))
+	assert.False(t, hostLocalCheckpointExists(t, ip))
+}
+
+// TestSandboxNetworkDelFailureDuringStop covers a DEL failure during
+// StopPodSandbox using the shared cniFailpoint harness: the sandbox must stay
+// listed and its IP must remain in the host-local checkpoint until the
+// plugin is restored and the stop is retried.
+func TestSandboxNetworkDelFailureDuringStop(t *testing.T) {
+	config, err := CRIConfig()
+	require.NoError(t, err)
+	fs, err := ioutil.ReadDir(config.NetworkPluginConfDir)
+	require.NoError(t, err)
+	require.NotEmpty(t, fs)
+	cniConfig, err := ioutil.ReadFile(filepath.Join(config.NetworkPluginConfDir, fs[0].Name()))
+	require.NoError(t, err)
+	if !strings.Contains(string(cniConfig), "host-local") || !strings.Contains(string(cniConfig), "portmap") {
+		t.Skip("host-local ipam with portmap chained is not in use")
+	}
+
+	sbConfig := PodSandboxConfig("sandbox", "network-del-failure-during-stop")
+	id, err := runtimeService.RunPodSandbox(sbConfig)
+	require.NoError(t, err)
+	defer func() {
+		runtimeService.StopPodSandbox(id)
+		runtimeService.RemovePodSandbox(id)
+	}()
+
+	status, err := runtimeService.PodSandboxStatus(id)
+	require.NoError(t, err)
+	ip := status.GetNetwork().GetIp()
+	require.NotEmpty(t, ip)
+	require.True(t, hostLocalCheckpointExists(t, ip))
+
+	fp := breakCNIPlugin(t, "portmap")
+	defer fp.restore()
+
+	assert.Error(t, runtimeService.StopPodSandbox(id), "StopPodSandbox should fail while the plugin is broken")
+
+	sandboxes, err := runtimeService.ListPodSandbox(nil)
+	require.NoError(t, err)
+	assert.NotNil(t, findSandbox(sandboxes, "sandbox", "network-del-failure-during-stop"))
+	assert.True(t, hostLocalCheckpointExists(t, ip))
+
+	pids, err := PidsOf("portmap")
+	require.NoError(t, err)
+	assert.Empty(t, pids, "no portmap child process should be left running")
+
+	require.NoError(t, fp.restore())
+
+	assert.NoError(t, runtimeService.StopPodSandbox(id))
+	assert.NoError(t, runtimeService.RemovePodSandbox(id))
+	assert.False(t, hostLocalCheckpointExists(t, ip))
+}
@@ -20,6 +20,7 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 	"testing"
@@ -125,6 +126,7 @@ func TestSandboxRemoveWithoutIPLeakage(t *testing.T) {
 		}
 	}
 	require.NotEmpty(t, netNS, "network namespace should be set")
+	assert.True(t, sbInfo.NetworkReady, "networkReady should be true once setupPodNetwork succeeds")
 
 	t.Logf("Should be able to find the pod ip in host-local checkpoint")
 	checkIP := func(ip string) bool {
@@ -162,13 +164,227 @@ func TestSandboxRemoveWithoutIPLeakage(t *testing.T) {
 	_, err = os.Stat(netNS)
 	assert.True(t, os.IsNotExist(err))
 
+	t.Logf("networkReady should not be carried over as true across a restart")
+	resp, err = client.PodSandboxStatus(ctx, &runtime.PodSandboxStatusRequest{
+		PodSandboxId: sb,
+		Verbose:      true,
+	})
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal([]byte(resp.GetInfo()["info"]), &sbInfo))
+	assert.False(t, sbInfo.NetworkReady, "networkReady should be false for a sandbox recovered after restart")
+
 	t.Logf("Should still be able to find the pod ip in host-local checkpoint")
 	assert.True(t, checkIP(ip))
 
 	t.Logf("Should be able to remove the sandbox after properly stopped")
 	assert.NoError(t, runtimeService.StopPodSandbox(sb))
+
+	resp, err = client.PodSandboxStatus(ctx, &runtime.PodSandboxStatusRequest{
+		PodSandboxId: sb,
+		Verbose:      true,
+	})
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal([]byte(resp.GetInfo()["info"]), &sbInfo))
+	assert.False(t, sbInfo.NetworkReady, "networkReady should be false once the network has been torn down")
+
 	assert.NoError(t, runtimeService.RemovePodSandbox(sb))
 
 	t.Logf("Should not be able to find the pod ip in host-local checkpoint")
 	assert.False(t, checkIP(ip))
 }
+
+// TestSandboxRemoveOnNetworkTeardownFailure verifies that a transient CNI DEL
+// failure on a chained plugin does not leak the sandbox's IP allocation:
+// the sandbox must stay listed and RemovePodSandbox must keep failing until
+// teardown is retried and actually succeeds.
+func TestSandboxRemoveOnNetworkTeardownFailure(t *testing.T) {
+	t.Logf("Make sure portmap is chained into the CNI config")
+	config, err := CRIConfig()
+	require.NoError(t, err)
+	fs, err := ioutil.ReadDir(config.NetworkPluginConfDir)
+	require.NoError(t, err)
+	require.NotEmpty(t, fs)
+	f := filepath.Join(config.NetworkPluginConfDir, fs[0].Name())
+	cniConfig, err := ioutil.ReadFile(f)
+	require.NoError(t, err)
+	if !strings.Contains(string(cniConfig), "portmap") {
+		t.Skip("portmap is not chained into the CNI config")
+	}
+
+	t.Logf("Create a sandbox")
+	sbConfig := PodSandboxConfig("sandbox", "remove-on-network-teardown-failure")
+	sb, err := runtimeService.RunPodSandbox(sbConfig)
+	require.NoError(t, err)
+	defer func() {
+		// Make sure the sandbox is cleaned up in any case.
+		runtimeService.StopPodSandbox(sb)
+		runtimeService.RemovePodSandbox(sb)
+	}()
+
+	status, err := runtimeService.PodSandboxStatus(sb)
+	require.NoError(t, err)
+	ip := status.GetNetwork().GetIp()
+	require.NotEmpty(t, ip)
+	require.True(t, hostLocalCheckpointExists(t, ip))
+
+	t.Logf("Break the portmap plugin so CNI DEL fails")
+	fp := breakCNIPlugin(t, "portmap")
+	defer fp.restore()
+
+	t.Logf("StopPodSandbox should fail while the plugin is broken")
+	assert.Error(t, runtimeService.StopPodSandbox(sb))
+
+	t.Logf("Sandbox should still be listed")
+	sandboxes, err := runtimeService.ListPodSandbox(nil)
+	require.NoError(t, err)
+	assert.True(t, sandboxExists(sandboxes, sb))
+
+	t.Logf("RemovePodSandbox should keep failing while teardown has not succeeded")
+	assert.Error(t, runtimeService.RemovePodSandbox(sb))
+
+	t.Logf("Should still be able to find the pod ip in host-local checkpoint")
+	assert.True(t, hostLocalCheckpointExists(t, ip))
+
+	t.Logf("Restore the portmap plugin and retry teardown")
+	require.NoError(t, fp.restore())
+
+	assert.NoError(t, runtimeService.StopPodSandbox(sb))
+	assert.NoError(t, runtimeService.RemovePodSandbox(sb))
+
+	t.Logf("Should not be able to find the pod ip in host-local checkpoint")
+	assert.False(t, hostLocalCheckpointExists(t, ip))
+}
+
+// TestSandboxCreateNetworkOrdering verifies that a failure creating the
+// sandbox container never leaks a CNI IP allocation: setupPodNetwork only
+// runs once the sandbox container/task already exists, so a failure before
+// that point has no network state to unwind, and a failure in
+// setupPodNetwork itself leaves the sandbox record in place so CNI teardown
+// can be retried against it.
+func TestSandboxCreateNetworkOrdering(t *testing.T) {
+	const hostLocalCheckpointDir = "/var/lib/cni"
+
+	config, err := CRIConfig()
+	require.NoError(t, err)
+	fs, err := ioutil.ReadDir(config.NetworkPluginConfDir)
+	require.NoError(t, err)
+	require.NotEmpty(t, fs)
+	f := filepath.Join(config.NetworkPluginConfDir, fs[0].Name())
+	cniConfig, err := ioutil.ReadFile(f)
+	require.NoError(t, err)
+	if !strings.Contains(string(cniConfig), "host-local") {
+		t.Skip("host-local ipam is not in use")
+	}
+
+	checkpointEmpty := func() bool {
+		empty := true
+		filepath.Walk(hostLocalCheckpointDir, func(path string, info os.FileInfo, _ error) error {
+			if info != nil && !info.IsDir() {
+				empty = false
+			}
+			return nil
+		})
+		return empty
+	}
+
+	t.Logf("Break the CNI bridge plugin so setupPodNetwork fails after the sandbox container is created")
+	fp := breakCNIPlugin(t, "bridge")
+	defer fp.restore()
+
+	sbConfig := PodSandboxConfig("sandbox", "create-network-ordering")
+	_, err = runtimeService.RunPodSandbox(sbConfig)
+	require.Error(t, err, "RunPodSandbox should fail when network setup fails")
+
+	t.Logf("No IP should have been leaked into the host-local checkpoint")
+	assert.True(t, checkpointEmpty())
+
+	t.Logf("Restore the bridge plugin")
+	require.NoError(t, fp.restore())
+
+	t.Logf("A sandbox should now be created successfully with no IP leaked by the failed attempt")
+	sb, err := runtimeService.RunPodSandbox(PodSandboxConfig("sandbox", "create-network-ordering"))
+	require.NoError(t, err)
+	defer func() {
+		runtimeService.StopPodSandbox(sb)
+		runtimeService.RemovePodSandbox(sb)
+	}()
+	assert.NoError(t, runtimeService.StopPodSandbox(sb))
+	assert.NoError(t, runtimeService.RemovePodSandbox(sb))
+	assert.True(t, checkpointEmpty())
+}
+
+// TestSandboxCreateContainerFailureNoNetworkLeak covers the other stage of
+// the reorder: a failure creating the sandbox container/task itself, before
+// the network has been touched at all. It must leave no CNI state behind,
+// since setupPodNetwork never runs in that case.
+func TestSandboxCreateContainerFailureNoNetworkLeak(t *testing.T) {
+	const hostLocalCheckpointDir = "/var/lib/cni"
+
+	config, err := CRIConfig()
+	require.NoError(t, err)
+	fs, err := ioutil.ReadDir(config.NetworkPluginConfDir)
+	require.NoError(t, err)
+	require.NotEmpty(t, fs)
+	cniConfig, err := ioutil.ReadFile(filepath.Join(config.NetworkPluginConfDir, fs[0].Name()))
+	require.NoError(t, err)
+	if !strings.Contains(string(cniConfig), "host-local") {
+		t.Skip("host-local ipam is not in use")
+	}
+
+	runtimePath, err := exec.LookPath("containerd-shim-runc-v2")
+	if err != nil {
+		t.Skip("containerd-shim-runc-v2 not found on PATH")
+	}
+
+	checkpointEmpty := func() bool {
+		empty := true
+		filepath.Walk(hostLocalCheckpointDir, func(path string, info os.FileInfo, _ error) error {
+			if info != nil && !info.IsDir() {
+				empty = false
+			}
+			return nil
+		})
+		return empty
+	}
+
+	t.Logf("Break the OCI runtime shim so sandbox container/task creation fails")
+	broken := runtimePath + ".disabled"
+	require.NoError(t, os.Rename(runtimePath, broken))
+	restored := false
+	defer func() {
+		if !restored {
+			os.Rename(broken, runtimePath)
+		}
+	}()
+
+	sbConfig := PodSandboxConfig("sandbox", "create-container-failure-no-network-leak")
+	_, err = runtimeService.RunPodSandbox(sbConfig)
+	require.Error(t, err, "RunPodSandbox should fail when the sandbox container cannot be created")
+
+	t.Logf("No CNI state should have been created, since the network was never touched")
+	assert.True(t, checkpointEmpty())
+
+	t.Logf("Restore the OCI runtime shim")
+	require.NoError(t, os.Rename(broken, runtimePath))
+	restored = true
+
+	t.Logf("A sandbox should now be created successfully with no leftover state from the failed attempt")
+	sb, err := runtimeService.RunPodSandbox(PodSandboxConfig("sandbox", "create-container-failure-no-network-leak"))
+	require.NoError(t, err)
+	defer func() {
+		runtimeService.StopPodSandbox(sb)
+		runtimeService.RemovePodSandbox(sb)
+	}()
+	assert.NoError(t, runtimeService.StopPodSandbox(sb))
+	assert.NoError(t, runtimeService.RemovePodSandbox(sb))
+	assert.True(t, checkpointEmpty())
+}
+
+func sandboxExists(sandboxes []*runtime.PodSandbox, id string) bool {
+	for _, sb := range sandboxes {
+		if sb.GetId() == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package netns manages network namespaces that are bind mounted under a
+// well-known directory, so that CRI controls their lifetime independently of
+// the sandbox container process that joins them.
+package netns
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+)
+
+// NetNS holds a network namespace bind mounted at a fixed path.
+type NetNS struct {
+	sync.Mutex
+	closed bool
+	path   string
+}
+
+// NewNetNS creates a network namespace for the sandbox with the given id and
+// bind mounts it at a path under baseDir, returning a handle to it. id must
+// be unique per sandbox so namespaces from different sandboxes never share a
+// path.
+func NewNetNS(baseDir, id string) (*NetNS, error) {
+	if err := os.MkdirAll(baseDir, 0755); err != nil {
+		return nil, errors.Wrap(err, "failed to create net namespace base dir")
+	}
+	path := filepath.Join(baseDir, fmt.Sprintf("cni-%s", id))
+	if err := createNetNS(path); err != nil {
+		return nil, errors.Wrap(err, "failed to create network namespace")
+	}
+	return &NetNS{path: path}, nil
+}
+
+// LoadNetNS loads an existing network namespace from the given path.
+func LoadNetNS(path string) *NetNS {
+	return &NetNS{path: path}
+}
+
+// createNetNS creates a new network namespace and bind mounts it at nsPath,
+// leaving the calling thread's namespace unchanged.
+func createNetNS(nsPath string) error {
+	mountPointFd, err := os.Create(nsPath)
+	if err != nil {
+		return err
+	}
+	mountPointFd.Close()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	var createErr error
+	go func() {
+		defer wg.Done()
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+
+		origNS, err := netns.GetFromThread()
+		if err != nil {
+			createErr = errors.Wrap(err, "failed to get current network namespace")
+			return
+		}
+		defer origNS.Close()
+
+		if err := unix.Unshare(unix.CLONE_NEWNET); err != nil {
+			createErr = errors.Wrap(err, "failed to unshare network namespace")
+			return
+		}
+		defer netns.Set(origNS)
+
+		newNS, err := netns.GetFromThread()
+		if err != nil {
+			createErr = errors.Wrap(err, "failed to get new network namespace")
+			return
+		}
+		defer newNS.Close()
+
+		if err := unix.Mount(fmt.Sprintf("/proc/self/fd/%d", int(newNS)), nsPath, "none", unix.MS_BIND, ""); err != nil {
+			createErr = errors.Wrap(err, "failed to bind mount network namespace")
+		}
+	}()
+	wg.Wait()
+	return createErr
+}
+
+// Remove tears down the bind mounted network namespace.
+func (n *NetNS) Remove() error {
+	n.Lock()
+	defer n.Unlock()
+	if n.closed {
+		return nil
+	}
+	if err := unix.Unmount(n.path, unix.MNT_DETACH); err != nil && err != unix.EINVAL {
+		return errors.Wrap(err, "failed to unmount network namespace")
+	}
+	if err := os.RemoveAll(n.path); err != nil {
+		return errors.Wrap(err, "failed to remove network namespace file")
+	}
+	n.closed = true
+	return nil
+}
+
+// Closed reports whether the network namespace has already been torn down.
+func (n *NetNS) Closed() bool {
+	n.Lock()
+	defer n.Unlock()
+	return n.closed
+}
+
+// GetPath returns the bind mount path of the network namespace.
+func (n *NetNS) GetPath() string {
+	return n.path
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"path/filepath"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/errdefs"
+	cni "github.com/containerd/go-cni"
+	"github.com/pborman/uuid"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	runtime "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+
+	"github.com/containerd/cri/pkg/store/sandbox"
+)
+
+// generateSandboxIDAndName generates a unique id and the expected cri name
+// for a sandbox from its config.
+func (c *criService) generateSandboxIDAndName(config *runtime.PodSandboxConfig) (string, string, error) {
+	name := makeSandboxName(config.GetMetadata())
+	id := uuid.New()
+	for {
+		if _, err := c.sandboxStore.Get(id); err != nil {
+			break
+		}
+		id = uuid.New()
+	}
+	return id, name, nil
+}
+
+// stopSandboxContainer kills the sandbox container's task, if it is still
+// running, and waits for it to exit.
+func (c *criService) stopSandboxContainer(ctx context.Context, sb sandbox.Sandbox) error {
+	if sb.Container == nil {
+		return nil
+	}
+	task, err := sb.Container.Task(ctx, nil)
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			return nil
+		}
+		return errors.Wrap(err, "failed to get sandbox container task")
+	}
+	if _, err := task.Delete(ctx, containerd.WithProcessKill); err != nil {
+		return errors.Wrap(err, "failed to delete sandbox container task")
+	}
+	return nil
+}
+
+// podIP returns the first IP address allocated by a CNI result.
+func podIP(result *cni.CNIResult) string {
+	for _, ipc := range result.IPs {
+		if ipc.IP != nil {
+			return ipc.IP.String()
+		}
+	}
+	return ""
+}
+
+// makeSandboxName generates the cri sandbox name from metadata.
+func makeSandboxName(m *runtime.PodSandboxMetadata) string {
+	return filepath.Join(m.GetName(), m.GetNamespace(), m.GetUid())
+}
+
+// netNSMountDir is the directory CRI bind mounts sandbox network namespaces
+// into, independent of the sandbox container's own lifecycle.
+func (c *criService) netNSMountDir() string {
+	return filepath.Join(c.config.StateDir, "sandboxes")
+}
+
+// createSandboxContainer creates (but does not start) the containerd
+// container and task backing the given sandbox. It returns the created
+// container alongside the task: callers must persist the container back onto
+// the stored Sandbox (via sandboxStore.Update) themselves, since Sandbox is
+// stored by value.
+func (c *criService) createSandboxContainer(ctx context.Context, sb sandbox.Sandbox) (containerd.Container, containerd.Task, error) {
+	cntr, err := c.client.NewContainer(ctx, sb.ID)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to create containerd container")
+	}
+	task, err := cntr.NewTask(ctx, containerd.NullIO)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to create containerd task")
+	}
+	return cntr, task, nil
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	runtime "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+
+	"github.com/containerd/cri/pkg/netns"
+	"github.com/containerd/cri/pkg/store/sandbox"
+)
+
+// RemovePodSandbox removes the sandbox. It fails if the sandbox is still
+// running, or if its network was never torn down successfully: retrying
+// RemovePodSandbox in that case would otherwise drop the sandbox metadata
+// (and the netns + CNI result recorded on it) while the CNI allocation is
+// still live, permanently leaking the IP under the host-local checkpoint
+// directory.
+func (c *criService) RemovePodSandbox(ctx context.Context, r *runtime.RemovePodSandboxRequest) (*runtime.RemovePodSandboxResponse, error) {
+	sb, err := c.sandboxStore.Get(r.GetPodSandboxId())
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to find sandbox %q", r.GetPodSandboxId())
+	}
+
+	status := sb.Status.Get()
+	if status.State != sandbox.StateNotReady {
+		return nil, errors.Errorf("sandbox %q is still running, must be stopped first", sb.ID)
+	}
+	if status.NetworkTeardownFailed {
+		return nil, errors.Errorf("sandbox %q network teardown previously failed, retry StopPodSandbox first", sb.ID)
+	}
+	c.netLock.Lock()
+	ready, known := c.networkReady[sb.ID]
+	c.netLock.Unlock()
+	if ready || !known {
+		return nil, errors.Errorf("sandbox %q network has not been torn down, must be stopped first", sb.ID)
+	}
+
+	if err := netns.LoadNetNS(sb.NetNSPath).Remove(); err != nil {
+		return nil, errors.Wrapf(err, "failed to remove network namespace for sandbox %q", sb.ID)
+	}
+
+	if sb.Container != nil {
+		if err := sb.Container.Delete(ctx); err != nil {
+			return nil, errors.Wrapf(err, "failed to delete sandbox container %q", sb.ID)
+		}
+	}
+
+	c.sandboxStore.Delete(sb.ID)
+	c.clearNetworkReady(sb.ID)
+
+	return &runtime.RemovePodSandboxResponse{}, nil
+}
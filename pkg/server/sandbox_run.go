@@ -0,0 +1,156 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"github.com/containerd/containerd"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	runtime "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+
+	"github.com/containerd/cri/pkg/netns"
+	"github.com/containerd/cri/pkg/store/sandbox"
+)
+
+// RunPodSandbox creates and starts a pod-level sandbox. Runtimes should ensure
+// the sandbox is in the ready state on success.
+func (c *criService) RunPodSandbox(ctx context.Context, r *runtime.RunPodSandboxRequest) (res *runtime.RunPodSandboxResponse, retErr error) {
+	config := r.GetConfig()
+
+	id, name, err := c.generateSandboxIDAndName(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate sandbox id and name")
+	}
+
+	// networkSetupAttempted is flipped just before setupPodNetwork is called.
+	// Once it is true, a failure has a sandbox record + container + netns to
+	// retry CNI teardown against, so the defers below must leave all three in
+	// place instead of unwinding them like a pre-network failure would.
+	networkSetupAttempted := false
+
+	netNS, err := netns.NewNetNS(c.netNSMountDir(), id)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create network namespace for sandbox")
+	}
+	defer func() {
+		if retErr != nil && !networkSetupAttempted {
+			if rmErr := netNS.Remove(); rmErr != nil {
+				logrus.WithError(rmErr).Errorf("Failed to remove network namespace %s", netNS.GetPath())
+			}
+		}
+	}()
+
+	sb := sandbox.NewSandbox(
+		sandbox.Metadata{
+			ID:        id,
+			Name:      name,
+			Config:    config,
+			NetNSPath: netNS.GetPath(),
+		},
+		sandbox.Status{
+			State: sandbox.StateUnknown,
+		},
+	)
+
+	// Record the sandbox and create its container/task BEFORE touching the
+	// network. If container creation fails there is nothing to unwind on
+	// the network side yet; if setupPodNetwork fails below, the sandbox
+	// record and netns must be left in place (see networkSetupAttempted)
+	// so StopPodSandbox/RemovePodSandbox can retry CNI DEL against them,
+	// instead of racing a rollback that tears the netns down before DEL
+	// can run.
+	if err := c.sandboxStore.Add(sb); err != nil {
+		return nil, errors.Wrapf(err, "failed to add sandbox %+v into store", sb)
+	}
+	defer func() {
+		if retErr != nil && !networkSetupAttempted {
+			c.sandboxStore.Delete(id)
+		}
+	}()
+
+	cntr, task, err := c.createSandboxContainer(ctx, sb)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create sandbox container")
+	}
+	sb.Container = cntr
+	if err := c.sandboxStore.Update(sb); err != nil {
+		return nil, errors.Wrapf(err, "failed to update sandbox %q in store", id)
+	}
+	defer func() {
+		if retErr != nil && !networkSetupAttempted {
+			if _, delErr := task.Delete(ctx, containerd.WithProcessKill); delErr != nil {
+				logrus.WithError(delErr).Errorf("Failed to delete sandbox container task %q", id)
+			}
+		}
+	}()
+
+	// Persist whatever setupPodNetwork captured before inspecting its error:
+	// a chained CNI ADD can partially succeed (e.g. host-local IPAM allocates
+	// an IP before a later plugin fails). networkReady is only set once
+	// setupPodNetwork succeeds; after a failed ADD the sandbox has no
+	// networkReady entry, which makes the next StopPodSandbox's
+	// teardownPodNetwork run a real CNI DEL.
+	networkSetupAttempted = true
+	setupErr := c.setupPodNetwork(ctx, &sb)
+	if err := c.sandboxStore.Update(sb); err != nil {
+		return nil, errors.Wrapf(err, "failed to update sandbox %q in store", id)
+	}
+	if setupErr != nil {
+		return nil, errors.Wrapf(setupErr, "failed to setup network for sandbox %q", id)
+	}
+	c.setNetworkReady(id, true)
+	defer func() {
+		if retErr != nil {
+			if tdErr := c.teardownPodNetwork(ctx, sb); tdErr != nil {
+				logrus.WithError(tdErr).Errorf("Failed to destroy network for sandbox %q after failed start", id)
+			}
+		}
+	}()
+
+	if err := task.Start(ctx); err != nil {
+		return nil, errors.Wrapf(err, "failed to start sandbox container task %q", id)
+	}
+
+	if err := sb.Status.Update(func(status sandbox.Status) (sandbox.Status, error) {
+		status.Pid = task.Pid()
+		status.State = sandbox.StateReady
+		return status, nil
+	}); err != nil {
+		return nil, errors.Wrap(err, "failed to update sandbox status")
+	}
+
+	return &runtime.RunPodSandboxResponse{PodSandboxId: id}, nil
+}
+
+// setupPodNetwork sets up the network for a sandbox by calling the CNI
+// plugin chain. The sandbox's NetNSPath must already be set. It records
+// whatever CNI result comes back onto the sandbox even if the chain fails
+// partway through, since a chained ADD can have already allocated an IP
+// before a later plugin errors out; that result is what lets a later DEL be
+// retried with the exact same arguments.
+func (c *criService) setupPodNetwork(ctx context.Context, sb *sandbox.Sandbox) error {
+	result, err := c.netPlugin.Setup(sb.ID, sb.NetNSPath)
+	if result != nil {
+		sb.CNIResult = result
+		sb.IP = podIP(result)
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to call cni setup")
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"encoding/json"
+
+	runtimespec "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	runtime "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+
+	"github.com/containerd/cri/pkg/store/sandbox"
+)
+
+// SandboxInfo is the additional information returned for a sandbox via the
+// verbose PodSandboxStatus response, under the "info" key as a JSON string.
+type SandboxInfo struct {
+	Pid          uint32            `json:"pid"`
+	RuntimeSpec  *runtimespec.Spec `json:"runtimeSpec"`
+	NetworkReady bool              `json:"networkReady"`
+}
+
+// PodSandboxStatus returns the status of the pod sandbox.
+func (c *criService) PodSandboxStatus(ctx context.Context, r *runtime.PodSandboxStatusRequest) (*runtime.PodSandboxStatusResponse, error) {
+	sb, err := c.sandboxStore.Get(r.GetPodSandboxId())
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to find sandbox %q", r.GetPodSandboxId())
+	}
+	status := sb.Status.Get()
+
+	state := runtime.PodSandboxState_SANDBOX_NOTREADY
+	if status.State == sandbox.StateReady {
+		state = runtime.PodSandboxState_SANDBOX_READY
+	}
+
+	resp := &runtime.PodSandboxStatusResponse{
+		Status: &runtime.PodSandboxStatus{
+			Id:        sb.ID,
+			Metadata:  sb.Config.GetMetadata(),
+			State:     state,
+			CreatedAt: status.CreatedAt.UnixNano(),
+			Network:   &runtime.PodSandboxNetworkStatus{Ip: sb.IP},
+		},
+	}
+
+	if r.GetVerbose() {
+		info, err := c.toSandboxInfo(sb, status)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to marshal sandbox info")
+		}
+		resp.Info = info
+	}
+
+	return resp, nil
+}
+
+func (c *criService) toSandboxInfo(sb sandbox.Sandbox, status sandbox.Status) (map[string]string, error) {
+	si := &SandboxInfo{
+		Pid: status.Pid,
+		RuntimeSpec: &runtimespec.Spec{
+			Linux: &runtimespec.Linux{
+				Namespaces: []runtimespec.LinuxNamespace{
+					{Type: runtimespec.NetworkNamespace, Path: sb.NetNSPath},
+				},
+			},
+		},
+		NetworkReady: c.getNetworkReady(sb.ID),
+	}
+	b, err := json.Marshal(si)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"info": string(b)}, nil
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	runtime "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+
+	"github.com/containerd/cri/pkg/store/sandbox"
+)
+
+// StopPodSandbox stops the sandbox and its network. It is idempotent and can
+// safely be called repeatedly: if the network was already torn down it skips
+// CNI DEL, and if a previous teardown attempt failed it retries CNI DEL with
+// the same arguments instead of giving up.
+func (c *criService) StopPodSandbox(ctx context.Context, r *runtime.StopPodSandboxRequest) (*runtime.StopPodSandboxResponse, error) {
+	sb, err := c.sandboxStore.Get(r.GetPodSandboxId())
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to find sandbox %q", r.GetPodSandboxId())
+	}
+
+	if err := c.stopSandboxContainer(ctx, sb); err != nil {
+		return nil, errors.Wrapf(err, "failed to stop sandbox container %q", sb.ID)
+	}
+
+	if err := c.teardownPodNetwork(ctx, sb); err != nil {
+		return nil, errors.Wrapf(err, "failed to destroy network for sandbox %q", sb.ID)
+	}
+
+	return &runtime.StopPodSandboxResponse{}, nil
+}
+
+// teardownPodNetwork tears down the sandbox's CNI network, unless networkReady
+// has an entry saying it is already down and the last attempt did not fail.
+// networkReady is in memory only and starts empty, so a sandbox without an
+// entry (one whose CNI ADD failed, or any sandbox after a restart) is always
+// torn down, and the sandbox status's NetworkTeardownFailed flag makes a
+// failed DEL be retried on the next call.
+func (c *criService) teardownPodNetwork(ctx context.Context, sb sandbox.Sandbox) error {
+	status := sb.Status.Get()
+	c.netLock.Lock()
+	ready, known := c.networkReady[sb.ID]
+	c.netLock.Unlock()
+	if known && !ready && !status.NetworkTeardownFailed {
+		// Network was already torn down successfully; nothing to do.
+		return nil
+	}
+
+	delErr := c.netPlugin.Remove(sb.ID, sb.NetNSPath)
+	c.setNetworkReady(sb.ID, false)
+
+	return sb.Status.Update(func(status sandbox.Status) (sandbox.Status, error) {
+		status.State = sandbox.StateNotReady
+		status.NetworkTeardownFailed = delErr != nil
+		return status, delErr
+	})
+}
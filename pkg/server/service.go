@@ -0,0 +1,91 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"sync"
+
+	"github.com/containerd/containerd"
+	cni "github.com/containerd/go-cni"
+
+	"github.com/containerd/cri/pkg/store/sandbox"
+)
+
+// Config contains CRI plugin config used by the criService.
+type Config struct {
+	// StateDir is the root directory the CRI plugin stores state under.
+	StateDir string
+	// NetworkPluginBinDir is the directory in which the binaries for the CNI
+	// plugins are searched for.
+	NetworkPluginBinDir string
+	// NetworkPluginConfDir is the directory searched for CNI config files.
+	NetworkPluginConfDir string
+}
+
+// NewCRIService creates a criService.
+func NewCRIService(config Config, client *containerd.Client, netPlugin cni.CNI) *criService {
+	return &criService{
+		config:       config,
+		client:       client,
+		sandboxStore: sandbox.NewStore(),
+		netPlugin:    netPlugin,
+		networkReady: make(map[string]bool),
+	}
+}
+
+// criService implements CRI remote runtime and image service.
+type criService struct {
+	// config contains all configuration parameters for the CRI service.
+	config Config
+	// client is an instance of the containerd client.
+	client *containerd.Client
+	// sandboxStore stores all sandbox metadata.
+	sandboxStore *sandbox.Store
+	// netPlugin is used to setup and teardown sandbox network.
+	netPlugin cni.CNI
+	// netLock protects networkReady.
+	netLock sync.Mutex
+	// networkReady records whether a sandbox's network has been
+	// successfully set up and not yet torn down, keyed by sandbox id.
+	// This mirrors the pattern dockershim uses to avoid a redundant CNI
+	// DEL on repeated StopPodSandbox calls: it is an in-memory signal
+	// only, not persisted, and starts empty when the service is created.
+	networkReady map[string]bool
+}
+
+// getNetworkReady reports whether the network for the given sandbox is
+// currently considered ready.
+func (c *criService) getNetworkReady(id string) bool {
+	c.netLock.Lock()
+	defer c.netLock.Unlock()
+	return c.networkReady[id]
+}
+
+// setNetworkReady records whether the network for the given sandbox is ready.
+func (c *criService) setNetworkReady(id string, ready bool) {
+	c.netLock.Lock()
+	defer c.netLock.Unlock()
+	c.networkReady[id] = ready
+}
+
+// clearNetworkReady removes the network readiness entry for a sandbox. It
+// must only be called once the sandbox has been fully removed.
+func (c *criService) clearNetworkReady(id string) {
+	c.netLock.Lock()
+	defer c.netLock.Unlock()
+	delete(c.networkReady, id)
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sandbox
+
+import (
+	"github.com/containerd/containerd"
+	cni "github.com/containerd/go-cni"
+	runtime "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+// Sandbox contains all resources associated with the sandbox. All methods to
+// mutate the internal state are thread-safe.
+type Sandbox struct {
+	// Metadata is the metadata of the sandbox, it is immutable after created.
+	Metadata
+	// Status stores the status of the sandbox.
+	Status StatusStorage
+	// Container is the containerd container of the sandbox.
+	Container containerd.Container
+}
+
+// Metadata is the unchangeable information of a sandbox.
+type Metadata struct {
+	// ID is the sandbox id.
+	ID string
+	// Name is the sandbox name.
+	Name string
+	// Config is the CRI sandbox config.
+	Config *runtime.PodSandboxConfig
+	// NetNSPath is the network namespace used by the sandbox.
+	NetNSPath string
+	// IP is the IP address allocated to the sandbox network by CNI.
+	IP string
+	// CNIResult is the result returned by CNI ADD, recorded even when the
+	// plugin chain failed partway through, and kept around so a retried
+	// CNI DEL (e.g. after a teardown failure) can be invoked with the exact
+	// same arguments.
+	CNIResult *cni.CNIResult
+}
+
+// NewSandbox creates an internally used sandbox type. This functions reminds
+// us to always set the sandbox status after creating a sandbox.
+func NewSandbox(metadata Metadata, status Status) Sandbox {
+	s := Sandbox{Metadata: metadata}
+	s.Status = StoreStatus(status)
+	return s
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sandbox
+
+import (
+	"sync"
+	"time"
+)
+
+// State is the sandbox state.
+type State uint32
+
+const (
+	// StateUnknown is unknown state of sandbox. Sandbox is in unknown
+	// state before its corresponding sandbox container is created.
+	StateUnknown State = iota
+	// StateReady is ready state, it means sandbox container is running.
+	StateReady
+	// StateNotReady is notready state, it means sandbox container is not
+	// running. StopPodSandbox should still be called for a NOTREADY
+	// sandbox to make sure its resources are released.
+	StateNotReady
+)
+
+// Status is the status of a sandbox.
+type Status struct {
+	// Pid is the init process id of the sandbox container.
+	Pid uint32
+	// CreatedAt is the created timestamp.
+	CreatedAt time.Time
+	// State is the state of the sandbox.
+	State State
+	// NetworkTeardownFailed is true if the last attempt to tear down the
+	// sandbox's CNI network returned an error. While set, the network is
+	// considered still allocated: RemovePodSandbox must keep refusing the
+	// sandbox and StopPodSandbox must keep retrying CNI DEL with the same
+	// arguments, so a transient CNI failure can never leak the IP
+	// allocated under the host-local checkpoint directory.
+	NetworkTeardownFailed bool
+}
+
+// StatusStorage manages the status of a sandbox and provides a handler to
+// perform atomic update.
+type StatusStorage interface {
+	// Get a copy of the sandbox status.
+	Get() Status
+	// Update the sandbox status. The returned Status is always committed,
+	// even if the function also returns a non-nil error, so a mutator can
+	// both record a failure in the status and propagate it to the caller.
+	// Callers must not return the `Status` passed to the function from
+	// anywhere else, to avoid data race.
+	Update(func(Status) (Status, error)) error
+}
+
+// StoreStatus creates the storage containing the passed in sandbox status.
+func StoreStatus(status Status) StatusStorage {
+	return &statusStorage{status: status}
+}
+
+type statusStorage struct {
+	sync.Mutex
+	status Status
+}
+
+func (s *statusStorage) Get() Status {
+	s.Lock()
+	defer s.Unlock()
+	return s.status
+}
+
+func (s *statusStorage) Update(f func(Status) (Status, error)) error {
+	s.Lock()
+	defer s.Unlock()
+	newStatus, err := f(s.status)
+	// Always commit newStatus, even when f also returns an error: callers
+	// like teardownPodNetwork rely on being able to both persist a status
+	// change (e.g. NetworkTeardownFailed) and propagate the failure that
+	// caused it in the same call.
+	s.status = newStatus
+	return err
+}